@@ -2,6 +2,7 @@ package proxyclient
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,9 +29,14 @@ type httpProxyClient struct {
 	proxyAddr          string
 	proxyDomain        string // 用于ssl证书验证
 	proxyType          string // socks4 socks5
-							  //TODO: 用户名、密码
+	username           string // 代理用户名，"" 则不进行身份验证
+	password           string // 代理密码
+	mode               ForwardMode // 转发模式，默认 ModeConnect
 	insecureSkipVerify bool
 	upProxy            ProxyClient
+
+	digestNonceMu    sync.Mutex
+	digestNonceCount map[string]uint32 // Digest 身份验证 nc 计数，按 nonce 分别计数
 }
 
 // 创建代理客户端
@@ -39,6 +46,17 @@ type httpProxyClient struct {
 // insecureSkipVerify		使用https代理时是否忽略证书检查
 // UpProxy
 func NewHttpProxyClient(proxyType string, proxyAddr string, proxyDomain string, insecureSkipVerify bool, upProxy ProxyClient) (ProxyClient, error) {
+	return NewHttpProxyClientAuth(proxyType, proxyAddr, proxyDomain, "", "", insecureSkipVerify, upProxy)
+}
+
+// 创建带身份验证的代理客户端
+// ProxyType				http https
+// ProxyAddr 				127.0.0.1:5555
+// proxyDomain				ssl 验证域名，"" 则使用 proxyAddr 部分的域名
+// username、password		代理服务器身份验证用户名、密码，username 为空则不进行身份验证
+// insecureSkipVerify		使用https代理时是否忽略证书检查
+// UpProxy
+func NewHttpProxyClientAuth(proxyType string, proxyAddr string, proxyDomain string, username string, password string, insecureSkipVerify bool, upProxy ProxyClient) (ProxyClient, error) {
 	proxyType = strings.ToLower(strings.Trim(proxyType, " \r\n\t"))
 	if proxyType != "http" && proxyType != "https" {
 		return nil, errors.New("ProxyType 错误的格式，只支持http、https代理。")
@@ -60,7 +78,16 @@ func NewHttpProxyClient(proxyType string, proxyAddr string, proxyDomain string,
 		proxyDomain = host
 	}
 
-	return &httpProxyClient{proxyAddr, proxyDomain, proxyType, insecureSkipVerify, upProxy}, nil
+	return &httpProxyClient{
+		proxyAddr:          proxyAddr,
+		proxyDomain:        proxyDomain,
+		proxyType:          proxyType,
+		username:           username,
+		password:           password,
+		mode:               ModeConnect,
+		insecureSkipVerify: insecureSkipVerify,
+		upProxy:            upProxy,
+	}, nil
 }
 
 func (p *httpProxyClient) Dial(network, address string) (Conn, error) {
@@ -95,104 +122,160 @@ func (p *httpProxyClient) DialTCPSAddr(network string, raddr string) (ProxyTCPCo
 }
 
 
-func (p *httpProxyClient) DialTCPSAddrTimeout(network string, raddr string, timeout time.Duration) (rconn ProxyTCPConn, rerr error) {
-	// 截止时间
-	finalDeadline := time.Time{}
-	if timeout != 0 {
-		finalDeadline = time.Now().Add(timeout)
+func (p *httpProxyClient) DialTCPSAddrTimeout(network string, raddr string, timeout time.Duration) (ProxyTCPConn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	var tlsConn *tls.Conn
-	rawConn, err := p.upProxy.DialTCPSAddrTimeout(network, p.proxyAddr, timeout)
+	conn, err := p.DialContext(ctx, network, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(ProxyTCPConn), nil
+}
+
+// DialContext 与 DialTCPSAddrTimeout 等价，但以 ctx 控制超时与取消，可传入 net/http
+// Transport.DialContext 使用。CONNECT 方式下 ctx 被取消时，拨号过程中打开的连接会被立即关闭；
+// 绝对路径 URI 方式下只使用 ctx 的截止时间作为连接代理服务器的超时，ctx 被取消不会中断拨号。
+func (p *httpProxyClient) DialContext(ctx context.Context, network, address string) (Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("不支持的协议")
+	}
+
+	if p.useAbsoluteURI(address) {
+		timeout := time.Duration(0)
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+			if timeout <= 0 {
+				return nil, fmt.Errorf("timeout")
+			}
+		}
+		return p.dialAbsoluteURI(network, address, timeout)
+	}
+
+	return p.dialConnectContext(ctx, network, address)
+}
+
+func (p *httpProxyClient) dialConnectContext(ctx context.Context, network string, raddr string) (ProxyTCPConn, error) {
+	upTimeout := time.Duration(0)
+	if deadline, ok := ctx.Deadline(); ok {
+		upTimeout = time.Until(deadline)
+		if upTimeout <= 0 {
+			return nil, fmt.Errorf("timeout")
+		}
+	}
+
+	rawConn, err := p.upProxy.DialTCPSAddrTimeout(network, p.proxyAddr, upTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("无法连接代理服务器 %v ，错误：%v", p.proxyAddr, err)
 	}
 
+	// watcher 在 ctx 被取消/超时时关闭连接；done 在函数返回前一定会关闭，watcher 因此总会退出，不会泄漏。
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			rawConn.Close()
+		case <-done:
+		}
+	}()
+
 	var c Conn = rawConn
+	var tlsConn *tls.Conn
 
-	ch := make(chan int, 1)
-
-	// 实际执行部分
-	run := func() {
-		if p.proxyType == "https" {
-			tlsConn = tls.Client(c, &tls.Config{ServerName: p.proxyDomain, InsecureSkipVerify: p.insecureSkipVerify})
-			if err := tlsConn.Handshake(); err != nil {
-				tlsConn.Close()
-				rerr = fmt.Errorf("TLS 协议握手错误：%v", err)
-				ch <- 0
-				return
-			}
-			if p.insecureSkipVerify == false && tlsConn.VerifyHostname(p.proxyDomain) != nil {
-				tlsConn.Close()
-				rerr = fmt.Errorf("TLS 协议域名验证失败：%v", err)
-				ch <- 0
-				return
-			}
-			c = tlsConn
+	if p.proxyType == "https" {
+		tlsConn = tls.Client(c, &tls.Config{ServerName: p.proxyDomain, InsecureSkipVerify: p.insecureSkipVerify})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			tlsConn.Close()
+			return nil, fmt.Errorf("TLS 协议握手错误：%v", err)
+		}
+		if p.insecureSkipVerify == false && tlsConn.VerifyHostname(p.proxyDomain) != nil {
+			tlsConn.Close()
+			return nil, fmt.Errorf("TLS 协议域名验证失败")
 		}
+		c = tlsConn
+	}
 
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	newConnectRequest := func() (*http.Request, error) {
 		req, err := http.NewRequest("CONNECT", p.proxyAddr, nil)
 		if err != nil {
-			c.Close()
-			rerr = fmt.Errorf("创建请求错误：%v", err)
-			ch <- 0
-			return
+			return nil, err
 		}
 		req.URL.Path = raddr
 		req.URL.Host = p.proxyAddr
+		return req, nil
+	}
 
-		if err := req.Write(c); err != nil {
+	req, err := newConnectRequest()
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("创建请求错误：%v", err)
+	}
+
+	if err := req.Write(c); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("写请求错误：%v", err)
+	}
+
+	br := bufio.NewReader(c)
+
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("响应格式错误：%v", err)
+	}
+
+	// 代理服务器要求身份验证，按 Proxy-Authenticate 质询重新发起一次 CONNECT
+	if res.StatusCode == http.StatusProxyAuthRequired && p.username != "" {
+		authHeader, err := p.buildProxyAuthorization(res.Header.Values("Proxy-Authenticate"), raddr)
+		if err != nil {
 			c.Close()
-			rerr = fmt.Errorf("写请求错误：%v", err)
-			ch <- 0
-			return
+			return nil, fmt.Errorf("代理身份验证错误：%v", err)
 		}
 
-		br := bufio.NewReader(c)
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
 
-		res, err := http.ReadResponse(br, req)
+		req, err = newConnectRequest()
 		if err != nil {
 			c.Close()
-			rerr = fmt.Errorf("响应格式错误：%v", err)
-			ch <- 0
-			return
+			return nil, fmt.Errorf("创建请求错误：%v", err)
 		}
+		req.Header.Set("Proxy-Authorization", authHeader)
 
-		if res.StatusCode != 200 {
+		if err := req.Write(c); err != nil {
 			c.Close()
-			rerr = fmt.Errorf("响应错误：%v", res)
-			ch <- 0
-			return
+			return nil, fmt.Errorf("写请求错误：%v", err)
 		}
 
-		rconn = &HttpTCPConn{c, rawConn, tlsConn, net.TCPAddr{}, net.TCPAddr{}, "", "", 0, 0, p, res.Body}
-		ch <- 1
-		return
-	}
-
-
-	if timeout == 0 {
-		run()
-		return
-	} else {
-		c.SetDeadline(finalDeadline)
-
-		ntimeout := finalDeadline.Sub(time.Now())
-		if ntimeout <= 0 {
-			return nil, fmt.Errorf("timeout")
+		res, err = http.ReadResponse(br, req)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("响应格式错误：%v", err)
 		}
-		t := time.NewTimer(ntimeout)
-		defer t.Stop()
+	}
 
-		go run()
+	if res.StatusCode != 200 {
+		c.Close()
+		return nil, fmt.Errorf("响应错误：%v", res)
+	}
 
-		select {
-		case <-t.C:
-			return nil, fmt.Errorf("连接超时。")
-		case <-ch:
-			return
-		}
+	if err := ctx.Err(); err != nil {
+		c.Close()
+		return nil, err
 	}
+
+	return &HttpTCPConn{c, rawConn, tlsConn, net.TCPAddr{}, net.TCPAddr{}, "", "", 0, 0, p, res.Body}, nil
 }
 // 重写了 Read 接口
 // 由于 http 协议问题，解析响应需要读缓冲，所以必须重写 Read 来兼容读缓冲功能。
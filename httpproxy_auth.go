@@ -0,0 +1,144 @@
+package proxyclient
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// 返回 nonce 的下一个 nc 计数，同一 nonce 下从 1 开始递增，RFC 2617 要求
+// nc 必须按该 nonce 实际发起的请求序号递增，不能跨 nonce 共享。
+func (p *httpProxyClient) nextDigestNonceCount(nonce string) uint32 {
+	p.digestNonceMu.Lock()
+	defer p.digestNonceMu.Unlock()
+
+	if p.digestNonceCount == nil {
+		p.digestNonceCount = make(map[string]uint32)
+	}
+	p.digestNonceCount[nonce]++
+	return p.digestNonceCount[nonce]
+}
+
+// 根据代理服务器返回的 Proxy-Authenticate 质询，构造 Proxy-Authorization 请求头的值
+// raddr 为 CONNECT 请求的目标地址（host:port），用于构造 Digest 的 uri 字段
+func (p *httpProxyClient) buildProxyAuthorization(challenges []string, raddr string) (string, error) {
+	if len(challenges) == 0 {
+		return "", fmt.Errorf("代理服务器未返回 Proxy-Authenticate")
+	}
+
+	// 优先使用 Digest，其次退化为 Basic
+	var basicChallenge, digestChallenge string
+	for _, challenge := range challenges {
+		scheme, _ := parseAuthScheme(challenge)
+		switch strings.ToLower(scheme) {
+		case "digest":
+			digestChallenge = challenge
+		case "basic":
+			basicChallenge = challenge
+		}
+	}
+
+	if digestChallenge != "" {
+		return p.buildDigestAuthorization(digestChallenge, raddr)
+	}
+
+	if basicChallenge != "" {
+		return basicAuthorization(p.username, p.password), nil
+	}
+
+	return "", fmt.Errorf("不支持的身份验证方式：%v", challenges[0])
+}
+
+// 返回质询的方案名（basic、digest）与方案后面的参数串
+func parseAuthScheme(challenge string) (scheme string, params string) {
+	challenge = strings.TrimSpace(challenge)
+	idx := strings.IndexByte(challenge, ' ')
+	if idx < 0 {
+		return challenge, ""
+	}
+	return challenge[:idx], challenge[idx+1:]
+}
+
+func basicAuthorization(username, password string) string {
+	raw := username + ":" + password
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(raw))
+}
+
+// 解析 Digest 质询中形如 key="value", key2=value2 的参数列表
+func parseDigestParams(params string) map[string]string {
+	result := make(map[string]string)
+	for _, part := range strings.Split(params, ",") {
+		part = strings.TrimSpace(part)
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		result[key] = value
+	}
+	return result
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return fmt.Sprintf("%x", sum)
+}
+
+// 构造 RFC 2617 Digest 身份验证响应，支持 qop=auth
+func (p *httpProxyClient) buildDigestAuthorization(challenge string, raddr string) (string, error) {
+	_, paramStr := parseAuthScheme(challenge)
+	params := parseDigestParams(paramStr)
+
+	realm := params["realm"]
+	nonce := params["nonce"]
+	if nonce == "" {
+		return "", fmt.Errorf("Digest 质询缺少 nonce")
+	}
+	opaque := params["opaque"]
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	if !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("不支持的 Digest 算法：%v", algorithm)
+	}
+
+	qop := ""
+	for _, q := range strings.Split(params["qop"], ",") {
+		q = strings.TrimSpace(q)
+		if q == "auth" {
+			qop = "auth"
+			break
+		}
+	}
+
+	ha1 := md5Hex(fmt.Sprintf("%v:%v:%v", p.username, realm, p.password))
+	ha2 := md5Hex(fmt.Sprintf("CONNECT:%v", raddr))
+
+	var response, cnonce, nc string
+	if qop == "auth" {
+		nc = fmt.Sprintf("%08x", p.nextDigestNonceCount(nonce))
+		cnonceBuf := make([]byte, 8)
+		rand.Read(cnonceBuf)
+		cnonce = fmt.Sprintf("%x", cnonceBuf)
+		response = md5Hex(fmt.Sprintf("%v:%v:%v:%v:%v:%v", ha1, nonce, nc, cnonce, qop, ha2))
+	} else {
+		response = md5Hex(fmt.Sprintf("%v:%v:%v", ha1, nonce, ha2))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Digest ")
+	fmt.Fprintf(&sb, `username="%v", realm="%v", nonce="%v", uri="%v", response="%v", algorithm=%v`,
+		p.username, realm, nonce, raddr, response, algorithm)
+	if qop == "auth" {
+		fmt.Fprintf(&sb, `, qop=%v, nc=%v, cnonce="%v"`, qop, nc, cnonce)
+	}
+	if opaque != "" {
+		fmt.Fprintf(&sb, `, opaque="%v"`, opaque)
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,281 @@
+package proxyclient
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// 转发模式
+type ForwardMode int
+
+const (
+	ModeConnect     ForwardMode = iota // 使用 CONNECT 建立隧道，适用于 HTTPS 等任意协议
+	ModeAbsoluteURI                    // 使用绝对路径 URI 转发明文 HTTP 请求，适用于部分限制 CONNECT 的代理
+	ModeAuto                           // 根据目标端口自动选择：80 端口使用绝对路径 URI，其余使用 CONNECT
+)
+
+// 需要在转发时剥离的逐跳首部，RFC 7230 §6.1
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopByHopHeaders(header http.Header) {
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// 创建带转发模式的代理客户端
+// ProxyType				http https
+// ProxyAddr 				127.0.0.1:5555
+// proxyDomain				ssl 验证域名，"" 则使用 proxyAddr 部分的域名
+// username、password		代理服务器身份验证用户名、密码，username 为空则不进行身份验证
+// mode						ModeConnect、ModeAbsoluteURI、ModeAuto
+// insecureSkipVerify		使用https代理时是否忽略证书检查
+// UpProxy
+func NewHttpProxyClientMode(proxyType string, proxyAddr string, proxyDomain string, username string, password string, mode ForwardMode, insecureSkipVerify bool, upProxy ProxyClient) (ProxyClient, error) {
+	c, err := NewHttpProxyClientAuth(proxyType, proxyAddr, proxyDomain, username, password, insecureSkipVerify, upProxy)
+	if err != nil {
+		return nil, err
+	}
+	c.(*httpProxyClient).mode = mode
+	return c, nil
+}
+
+// 判断是否应当使用绝对路径 URI 转发：ModeAuto 下仅对明文 80 端口生效
+func (p *httpProxyClient) useAbsoluteURI(raddr string) bool {
+	if p.mode == ModeAbsoluteURI {
+		return true
+	}
+	if p.mode != ModeAuto {
+		return false
+	}
+	_, port, err := net.SplitHostPort(raddr)
+	return err == nil && port == "80"
+}
+
+// 建立与代理服务器之间的持久连接，后续请求以绝对路径 URI 的形式转发
+func (p *httpProxyClient) dialAbsoluteURI(network string, raddr string, timeout time.Duration) (ProxyTCPConn, error) {
+	rawConn, err := p.upProxy.DialTCPSAddrTimeout(network, p.proxyAddr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("无法连接代理服务器 %v ，错误：%v", p.proxyAddr, err)
+	}
+
+	var c Conn = rawConn
+
+	if p.proxyType == "https" {
+		tlsConn := tls.Client(c, &tls.Config{ServerName: p.proxyDomain, InsecureSkipVerify: p.insecureSkipVerify})
+		if err := tlsConn.Handshake(); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("TLS 协议握手错误：%v", err)
+		}
+		if p.insecureSkipVerify == false && tlsConn.VerifyHostname(p.proxyDomain) != nil {
+			c.Close()
+			return nil, fmt.Errorf("TLS 协议域名验证失败")
+		}
+		c = tlsConn
+	}
+
+	return &HttpForwardConn{
+		Conn:        c,
+		rawConn:     rawConn,
+		remoteAddr:  raddr,
+		proxyClient: p,
+		username:    p.username,
+		password:    p.password,
+		br:          bufio.NewReader(c),
+	}, nil
+}
+
+// HttpForwardConn 以绝对路径 URI 的形式，把写入的明文 HTTP 请求转发给代理服务器，
+// 并在读取响应时剥离逐跳首部，整个过程复用同一条与代理服务器的持久连接。
+type HttpForwardConn struct {
+	Conn                // 与代理服务器之间的连接
+	rawConn     TCPConn // 原始链接
+	remoteAddr  string  // 目标 host:port
+	username    string
+	password    string
+	proxyClient ProxyClient
+	br          *bufio.Reader
+
+	writeBuf      []byte
+	headerDone    bool
+	pendingMethod string // 当前这一轮请求的方法，供 Read 判断响应是否带 body
+
+	bodyReader io.Reader
+}
+
+func (c *HttpForwardConn) Write(b []byte) (n int, err error) {
+	if c.headerDone {
+		return c.Conn.Write(b)
+	}
+
+	c.writeBuf = append(c.writeBuf, b...)
+	idx := bytes.Index(c.writeBuf, []byte("\r\n\r\n"))
+	if idx < 0 {
+		// 请求头尚未写完整，先缓存等待后续 Write
+		return len(b), nil
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(c.writeBuf[:idx+4])))
+	if err != nil {
+		return 0, fmt.Errorf("解析请求错误：%v", err)
+	}
+
+	req.URL.Scheme = "http"
+	req.URL.Host = c.remoteAddr
+	req.Host = c.remoteAddr
+	removeHopByHopHeaders(req.Header)
+	if c.username != "" {
+		req.Header.Set("Proxy-Authorization", basicAuthorization(c.username, c.password))
+	}
+
+	// req.Write/WriteProxy 都会按 req.Body 校验写出的字节数与 Content-Length 是否一致，
+	// 而这里的 req 是只用请求头字节解析出来的，Body 天然是空的，body 真正的字节还在
+	// writeBuf 的 rest 部分里、尚未写出，用 WriteProxy 写会直接报 unexpected EOF。
+	// 所以请求行和首部自己写，原始 body 字节按未经改动的原样直接转发。
+	if err := writeAbsoluteURIRequestLine(c.Conn, req); err != nil {
+		return 0, err
+	}
+
+	c.pendingMethod = req.Method
+
+	rest := c.writeBuf[idx+4:]
+	c.headerDone = true
+	c.writeBuf = nil
+	if len(rest) > 0 {
+		if _, err := c.Conn.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// 写出绝对路径 URI 形式的请求行与首部：GET http://host/path HTTP/1.1
+// 不写 body，由调用方按 writeBuf 里原始未解析的字节原样转发。
+func writeAbsoluteURIRequestLine(w io.Writer, req *http.Request) error {
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "%s %s HTTP/1.1\r\n", req.Method, req.URL.String())
+	fmt.Fprintf(&head, "Host: %s\r\n", req.Host)
+	req.Header.Write(&head)
+	head.WriteString("\r\n")
+
+	_, err := w.Write(head.Bytes())
+	return err
+}
+
+func (c *HttpForwardConn) Read(b []byte) (n int, err error) {
+	if c.bodyReader == nil {
+		method := c.pendingMethod
+		if method == "" {
+			method = "GET"
+		}
+		res, err := http.ReadResponse(c.br, &http.Request{Method: method})
+		if err != nil {
+			return 0, fmt.Errorf("响应格式错误：%v", err)
+		}
+
+		removeHopByHopHeaders(res.Header)
+		// body 已被 http.ReadResponse 按原始 Transfer-Encoding/Content-Length 解码，
+		// 剥离 Transfer-Encoding 首部后必须重新成帧，否则这条持久连接上的下一个响应
+		// 将无法被正确定界。这里改用 chunked 重新编码，边读边转发，不整体缓冲 body，
+		// 以支持长轮询、SSE、大文件等流式响应。
+		// HEAD 请求的响应以及 1xx、204、304 响应不带 body，原样转发首部，不重新成帧。
+		var body io.Reader = res.Body
+		if method != "HEAD" && res.StatusCode/100 != 1 && res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotModified {
+			res.Header.Del("Content-Length")
+			res.Header.Set("Transfer-Encoding", "chunked")
+			body = newChunkedBodyReader(res.Body)
+		}
+
+		var head bytes.Buffer
+		fmt.Fprintf(&head, "HTTP/%d.%d %v\r\n", res.ProtoMajor, res.ProtoMinor, res.Status)
+		res.Header.Write(&head)
+		head.WriteString("\r\n")
+
+		c.bodyReader = io.MultiReader(&head, body)
+	}
+
+	n, err = c.bodyReader.Read(b)
+	if err == io.EOF {
+		// 一个完整响应读取结束，连接保持打开以便转发下一个请求；
+		// headerDone/writeBuf 必须一并重置，否则下一个请求会被当成 body 原样透传，
+		// 而不是重新解析成绝对路径 URI 形式。
+		c.bodyReader = nil
+		c.headerDone = false
+		c.writeBuf = nil
+		c.pendingMethod = ""
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return n, err
+}
+
+// chunkedBodyReader 把底层响应体边读边编码为 chunked 格式，不整体缓冲，
+// 用于在剥离 Transfer-Encoding/Content-Length 等逐跳首部后重新对响应体成帧。
+type chunkedBodyReader struct {
+	src io.ReadCloser
+	tmp []byte
+	buf bytes.Buffer
+	eof bool
+}
+
+func newChunkedBodyReader(src io.ReadCloser) *chunkedBodyReader {
+	return &chunkedBodyReader{src: src, tmp: make([]byte, 32*1024)}
+}
+
+func (r *chunkedBodyReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 && !r.eof {
+		n, err := r.src.Read(r.tmp)
+		if n > 0 {
+			fmt.Fprintf(&r.buf, "%x\r\n", n)
+			r.buf.Write(r.tmp[:n])
+			r.buf.WriteString("\r\n")
+		}
+		if err == io.EOF {
+			r.buf.WriteString("0\r\n\r\n")
+			r.eof = true
+			r.src.Close()
+		} else if err != nil {
+			r.src.Close()
+			return 0, err
+		}
+	}
+	return r.buf.Read(p)
+}
+
+func (c *HttpForwardConn) SetLinger(sec int) error {
+	return c.rawConn.SetLinger(sec)
+}
+
+func (c *HttpForwardConn) SetNoDelay(noDelay bool) error {
+	return c.rawConn.SetNoDelay(noDelay)
+}
+
+func (c *HttpForwardConn) SetReadBuffer(bytes int) error {
+	return c.rawConn.SetReadBuffer(bytes)
+}
+
+func (c *HttpForwardConn) SetWriteBuffer(bytes int) error {
+	return c.rawConn.SetWriteBuffer(bytes)
+}
+
+func (c *HttpForwardConn) ProxyClient() ProxyClient {
+	return c.proxyClient
+}
@@ -0,0 +1,107 @@
+package proxyclient
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeForwardConn 是一个最小化的 net.Conn 实现，仅用于捕获 HttpForwardConn.Write
+// 实际发往代理服务器的字节、以及按需回放 resp 中预置的响应字节，不做真实的网络 IO。
+type fakeForwardConn struct {
+	net.Conn
+	written bytes.Buffer
+	resp    bytes.Buffer
+}
+
+func (c *fakeForwardConn) Write(b []byte) (int, error)        { return c.written.Write(b) }
+func (c *fakeForwardConn) Read(b []byte) (int, error)         { return c.resp.Read(b) }
+func (c *fakeForwardConn) Close() error                       { return nil }
+func (c *fakeForwardConn) LocalAddr() net.Addr                { return nil }
+func (c *fakeForwardConn) RemoteAddr() net.Addr               { return nil }
+func (c *fakeForwardConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fakeForwardConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fakeForwardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestHttpForwardConnWriteUsesAbsoluteURI(t *testing.T) {
+	fake := &fakeForwardConn{}
+	c := &HttpForwardConn{
+		Conn:       fake,
+		remoteAddr: "example.com:80",
+	}
+
+	req := "GET /path?x=1 HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	if _, err := c.Write([]byte(req)); err != nil {
+		t.Fatalf("Write 返回错误：%v", err)
+	}
+
+	sent := fake.written.String()
+	if !strings.HasPrefix(sent, "GET http://example.com:80/path?x=1 HTTP/1.1\r\n") {
+		t.Fatalf("期望转发绝对路径 URI 请求行，实际发送：%q", sent)
+	}
+}
+
+// 带 body 的请求（POST），请求行与首部之外的原始字节必须原样转发，
+// 不能走 req.WriteProxy 那样按 Content-Length 校验 body 长度的路径。
+func TestHttpForwardConnWriteForwardsBody(t *testing.T) {
+	fake := &fakeForwardConn{}
+	c := &HttpForwardConn{
+		Conn:       fake,
+		remoteAddr: "example.com:80",
+	}
+
+	body := "a=1&b=2"
+	req := "POST /submit HTTP/1.1\r\nHost: example.com\r\nContent-Length: 7\r\n\r\n" + body
+	if _, err := c.Write([]byte(req)); err != nil {
+		t.Fatalf("Write 返回错误：%v", err)
+	}
+
+	sent := fake.written.String()
+	if !strings.HasPrefix(sent, "POST http://example.com:80/submit HTTP/1.1\r\n") {
+		t.Fatalf("期望转发绝对路径 URI 请求行，实际发送：%q", sent)
+	}
+	if !strings.HasSuffix(sent, body) {
+		t.Fatalf("期望原样转发请求 body %q，实际发送：%q", body, sent)
+	}
+}
+
+// 同一条连接上的第二个请求也必须被重写为绝对路径 URI 形式，
+// 验证 Read 在读完一个完整响应后正确重置 headerDone/writeBuf/pendingMethod。
+func TestHttpForwardConnReusesConnectionForSecondRequest(t *testing.T) {
+	fake := &fakeForwardConn{}
+	fake.resp.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	c := &HttpForwardConn{
+		Conn:       fake,
+		remoteAddr: "example.com:80",
+		br:         bufio.NewReader(fake),
+	}
+
+	if _, err := c.Write([]byte("GET /first HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+		t.Fatalf("第一次 Write 返回错误：%v", err)
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		_, err := c.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("第一次 Read 返回错误：%v", err)
+		}
+	}
+
+	fake.written.Reset()
+	if _, err := c.Write([]byte("GET /second HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+		t.Fatalf("第二次 Write 返回错误：%v", err)
+	}
+
+	sent := fake.written.String()
+	if !strings.HasPrefix(sent, "GET http://example.com:80/second HTTP/1.1\r\n") {
+		t.Fatalf("期望第二个请求同样被转发为绝对路径 URI，实际发送：%q", sent)
+	}
+}
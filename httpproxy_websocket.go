@@ -0,0 +1,75 @@
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// 通过 CONNECT 隧道转发 WebSocket 连接
+// urlStr		ws:// 或 wss:// 形式的 WebSocket 地址
+// header		握手时附带的额外请求头
+//
+// HttpTCPConn.Read 在 CONNECT 握手后会继续从 res.Body 的读缓冲区读取数据，
+// 这保证了 CONNECT 响应之后、握手尚未消费的数据不会丢失，因而可以直接把
+// 隧道连接交给 gorilla/websocket 完成 Upgrade 握手，从而让 WS/WSS 流量
+// 穿过任意一条由 ProxyClient 组成的代理链。
+func (p *httpProxyClient) DialWebSocket(urlStr string, header http.Header) (*websocket.Conn, Conn, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("urlStr 格式错误：%v", err)
+	}
+
+	var useTLS bool
+	switch strings.ToLower(u.Scheme) {
+	case "ws":
+		useTLS = false
+	case "wss":
+		useTLS = true
+	default:
+		return nil, nil, fmt.Errorf("不支持的协议：%v，只支持 ws、wss。", u.Scheme)
+	}
+
+	host := u.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if useTLS {
+			host = net.JoinHostPort(host, "443")
+		} else {
+			host = net.JoinHostPort(host, "80")
+		}
+	}
+
+	tunnel, err := p.dialConnectContext(context.Background(), "tcp", host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("建立 CONNECT 隧道错误：%v", err)
+	}
+
+	var c Conn = tunnel
+
+	if useTLS {
+		serverName, _, _ := net.SplitHostPort(host)
+		tlsConn := tls.Client(c, &tls.Config{ServerName: serverName})
+		if err := tlsConn.Handshake(); err != nil {
+			c.Close()
+			return nil, nil, fmt.Errorf("TLS 协议握手错误：%v", err)
+		}
+		c = tlsConn
+	}
+
+	wsConn, res, err := websocket.NewClient(c, u, header, 0, 0)
+	if err != nil {
+		c.Close()
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, nil, fmt.Errorf("WebSocket 握手错误：%v", err)
+	}
+
+	return wsConn, tunnel, nil
+}
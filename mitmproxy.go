@@ -0,0 +1,277 @@
+package proxyclient
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MitmProxyClient 在 CONNECT 隧道建立之后，使用用户提供的 CA 为目标域名签发叶子证书，
+// 对客户端扮演服务端、对真实源站扮演客户端，从而解密并检视隧道内的 TLS 流量。
+// 解密后的明文请求、响应分别经过 OnRequest、OnResponse 回调，供调用方检视或修改。
+type MitmProxyClient struct {
+	upstream ProxyClient // 用于连接真实源站的上游代理（拨号由 upstream 完成，可以是任意 ProxyClient 实现）
+	ca       tls.Certificate
+	caX509   *x509.Certificate
+	leafs    *mitmLeafCache
+
+	OnRequest  func(*http.Request) *http.Request
+	OnResponse func(*http.Response) *http.Response
+}
+
+// 创建 MITM 代理客户端
+// upstream			用于连接真实源站的上游代理，nil 则使用直连
+// ca				用于签发叶子证书的 CA 证书与私钥
+func NewMitmProxyClient(upstream ProxyClient, ca tls.Certificate) (*MitmProxyClient, error) {
+	if upstream == nil {
+		nUpstream, err := NewDriectProxyClient("")
+		if err != nil {
+			return nil, fmt.Errorf("创建直连代理错误：%v", err)
+		}
+		upstream = nUpstream
+	}
+
+	caX509, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("解析 CA 证书错误：%v", err)
+	}
+
+	return &MitmProxyClient{
+		upstream: upstream,
+		ca:       ca,
+		caX509:   caX509,
+		leafs:    newMitmLeafCache(256),
+	}, nil
+}
+
+// 从磁盘加载 CA 证书与私钥（PEM 格式）
+func LoadMitmCA(certFile, keyFile string) (tls.Certificate, error) {
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// 生成一张自签名的 CA 证书与私钥，可用 SaveMitmCA 持久化到磁盘
+func GenerateMitmCA(commonName string) (tls.Certificate, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("生成 CA 私钥错误：%v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("生成证书序列号错误：%v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("签发 CA 证书错误：%v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}, nil
+}
+
+// 将 CA 证书与私钥以 PEM 格式保存到磁盘
+func SaveMitmCA(ca tls.Certificate, certFile, keyFile string) error {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("保存 CA 证书错误：%v", err)
+	}
+
+	priv, ok := ca.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("仅支持保存 RSA 私钥")
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("保存 CA 私钥错误：%v", err)
+	}
+
+	return nil
+}
+
+// HandleConnect 处理一次已经读取完 CONNECT 请求行的客户端连接：
+// 向客户端返回 200 建立隧道、完成到客户端的 TLS 服务端握手、
+// 再通过 upstream 拨号到 host 并完成到源站的 TLS 客户端握手，
+// 随后在两端之间转发解密后的 HTTP 请求与响应，经由 OnRequest、OnResponse 检视。
+func (m *MitmProxyClient) HandleConnect(clientConn net.Conn, host string) error {
+	if _, err := fmt.Fprintf(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return fmt.Errorf("回写 CONNECT 响应错误：%v", err)
+	}
+
+	serverName, _, err := net.SplitHostPort(host)
+	if err != nil {
+		serverName = host
+	}
+
+	leaf, err := m.leafs.get(serverName, m.generateLeaf)
+	if err != nil {
+		return fmt.Errorf("签发叶子证书错误：%v", err)
+	}
+
+	tlsClientConn := tls.Server(clientConn, &tls.Config{Certificates: []tls.Certificate{*leaf}})
+	if err := tlsClientConn.Handshake(); err != nil {
+		return fmt.Errorf("与客户端 TLS 握手错误：%v", err)
+	}
+	defer tlsClientConn.Close()
+
+	rawOrigin, err := m.upstream.DialTCPSAddr("tcp", host)
+	if err != nil {
+		return fmt.Errorf("无法连接源站 %v ，错误：%v", host, err)
+	}
+	tlsOriginConn := tls.Client(rawOrigin, &tls.Config{ServerName: serverName})
+	if err := tlsOriginConn.Handshake(); err != nil {
+		rawOrigin.Close()
+		return fmt.Errorf("与源站 TLS 握手错误：%v", err)
+	}
+	defer tlsOriginConn.Close()
+
+	clientBr := bufio.NewReader(tlsClientConn)
+	originBr := bufio.NewReader(tlsOriginConn)
+
+	for {
+		req, err := http.ReadRequest(clientBr)
+		if err != nil {
+			return nil
+		}
+
+		if m.OnRequest != nil {
+			req = m.OnRequest(req)
+		}
+
+		req.URL.Scheme = "https"
+		req.URL.Host = host
+		if err := req.Write(tlsOriginConn); err != nil {
+			return fmt.Errorf("转发请求到源站错误：%v", err)
+		}
+
+		res, err := http.ReadResponse(originBr, req)
+		if err != nil {
+			return fmt.Errorf("读取源站响应错误：%v", err)
+		}
+
+		if m.OnResponse != nil {
+			res = m.OnResponse(res)
+		}
+
+		if err := res.Write(tlsClientConn); err != nil {
+			return fmt.Errorf("转发响应到客户端错误：%v", err)
+		}
+	}
+}
+
+// 为 host 签发一张由 m.ca 签名的叶子证书
+func (m *MitmProxyClient) generateLeaf(host string) (*tls.Certificate, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("生成叶子证书私钥错误：%v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("生成证书序列号错误：%v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{host},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, m.caX509, &priv.PublicKey, m.ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("签发叶子证书错误：%v", err)
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der, m.ca.Certificate[0]}, PrivateKey: priv}, nil
+}
+
+// 按 SNI 缓存已签发的叶子证书的 LRU，签发过程加锁以避免同一主机在高并发下被重复签发
+type mitmLeafCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List // 最近使用的在前
+	pending  map[string]*sync.WaitGroup
+}
+
+type mitmLeafEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+func newMitmLeafCache(capacity int) *mitmLeafCache {
+	return &mitmLeafCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		pending:  make(map[string]*sync.WaitGroup),
+	}
+}
+
+func (c *mitmLeafCache) get(host string, gen func(string) (*tls.Certificate, error)) (*tls.Certificate, error) {
+	c.mu.Lock()
+	if el, ok := c.items[host]; ok {
+		c.order.MoveToFront(el)
+		cert := el.Value.(*mitmLeafEntry).cert
+		c.mu.Unlock()
+		return cert, nil
+	}
+
+	if wg, ok := c.pending[host]; ok {
+		c.mu.Unlock()
+		wg.Wait()
+		return c.get(host, gen)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	c.pending[host] = wg
+	c.mu.Unlock()
+
+	cert, err := gen(host)
+
+	c.mu.Lock()
+	delete(c.pending, host)
+	if err == nil {
+		el := c.order.PushFront(&mitmLeafEntry{host, cert})
+		c.items[host] = el
+		for c.order.Len() > c.capacity {
+			oldest := c.order.Back()
+			if oldest == nil {
+				break
+			}
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*mitmLeafEntry).host)
+		}
+	}
+	c.mu.Unlock()
+
+	wg.Done()
+
+	return cert, err
+}
@@ -0,0 +1,129 @@
+package proxyclient
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ParseProxyChain 解析形如 "http://user:pw@a:1,socks5://b:2" 的代理链描述，
+// 按逗号或 "->" 分隔多段代理 URL，依次串联 SetUpProxy，构造出一条多级代理链。
+// 链中最后一段是直接向目标发起请求的一跳，第一段经由直连到达。
+// 支持的 scheme：http、https、socks4、socks5、direct
+// 支持的 query 参数：
+//
+//	insecureSkipVerify	使用 https 代理时是否忽略证书检查，如 "true"、"1"
+//	proxyDomain			ssl 验证域名，不填则使用 host 部分
+func ParseProxyChain(spec string) (ProxyClient, error) {
+	parts := splitProxyChainSpec(spec)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("ParseProxyChain 格式错误：空的代理链")
+	}
+
+	upProxy, err := NewDriectProxyClient("")
+	if err != nil {
+		return nil, fmt.Errorf("创建直连代理错误：%v", err)
+	}
+
+	var client ProxyClient = upProxy
+	for i, part := range parts {
+		client, err = newProxyClientFromURL(part, client, i == 0)
+		if err != nil {
+			return nil, fmt.Errorf("解析代理链节点 %q 错误：%v", part, err)
+		}
+	}
+
+	return client, nil
+}
+
+func splitProxyChainSpec(spec string) []string {
+	sep := ","
+	if strings.Contains(spec, "->") {
+		sep = "->"
+	}
+
+	var parts []string
+	for _, part := range strings.Split(spec, sep) {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func newProxyClientFromURL(rawURL string, upProxy ProxyClient, isFirst bool) (ProxyClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("URL 格式错误：%v", err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+
+	if scheme == "direct" {
+		// direct 代表不经过任何代理直连目标，与链式转发互斥，只能出现在链的起始位置，
+		// 否则会悄悄丢弃此前已经建立好的 upProxy，链实际上在这里被切断。
+		if !isFirst {
+			return nil, fmt.Errorf("direct 只能出现在代理链的起始位置")
+		}
+		return NewDriectProxyClient("")
+	}
+
+	username := ""
+	password := ""
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+
+	query := u.Query()
+	insecureSkipVerify := false
+	if v := query.Get("insecureSkipVerify"); v != "" {
+		insecureSkipVerify, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("insecureSkipVerify 格式错误：%v", err)
+		}
+	}
+	proxyDomain := query.Get("proxyDomain")
+
+	switch scheme {
+	case "http", "https":
+		return NewHttpProxyClientAuth(scheme, u.Host, proxyDomain, username, password, insecureSkipVerify, upProxy)
+	case "socks4":
+		return NewSocks4ProxyClient(u.Host, upProxy)
+	case "socks5":
+		return NewSocks5ProxyClient(u.Host, username, password, upProxy)
+	default:
+		return nil, fmt.Errorf("不支持的代理类型：%v", u.Scheme)
+	}
+}
+
+// URL 返回描述该代理这一跳的 URL，可与 ParseProxyChain 搭配使用，
+// 包含 scheme、地址、用户名密码以及 insecureSkipVerify、proxyDomain 参数；
+// 上游代理（upProxy）与转发模式（mode）不包含在 URL 中，重新解析得到的客户端为直连上游、ModeConnect 模式。
+func (p *httpProxyClient) URL() *url.URL {
+	u := &url.URL{
+		Scheme: p.proxyType,
+		Host:   p.proxyAddr,
+	}
+	if p.username != "" {
+		u.User = url.UserPassword(p.username, p.password)
+	}
+
+	query := url.Values{}
+	if p.insecureSkipVerify {
+		query.Set("insecureSkipVerify", "true")
+	}
+	if host, _, err := net.SplitHostPort(p.proxyAddr); err == nil && p.proxyDomain != host {
+		query.Set("proxyDomain", p.proxyDomain)
+	}
+	u.RawQuery = query.Encode()
+
+	return u
+}
+
+func (p *httpProxyClient) String() string {
+	return p.URL().String()
+}